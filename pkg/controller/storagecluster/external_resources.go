@@ -2,17 +2,22 @@ package storagecluster
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
 	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/external"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
@@ -24,31 +29,105 @@ const (
 	externalCephRgwEndpointKey   = "endpoint"
 )
 
+const (
+	// cephObjectStoreKind carries the full external RGW configuration: one or more
+	// endpoints, whether they serve TLS, and an optional CA bundle for Noobaa to trust them
+	cephObjectStoreKind = "CephObjectStore"
+
+	externalRgwEndpointsDataKey = "endpoints"
+	externalRgwTLSDataKey       = "tls"
+	externalRgwCABundleDataKey  = "caBundle"
+
+	// externalRgwEndpointsConfigMapName holds the external RGW endpoints and TLS flag, read back
+	// by RgwEndpointsForNoobaa; the single-endpoint, colon-substituted externalRgwEndpoint label
+	// is still populated alongside it
+	externalRgwEndpointsConfigMapName = "ocs-external-rgw-endpoints"
+	// externalRgwCABundleSecretName holds the RGW CA bundle provided by the external cluster,
+	// read back by RgwEndpointsForNoobaa; nothing in this package mounts it into Noobaa
+	externalRgwCABundleSecretName = "ocs-external-rgw-ca-bundle"
+	externalRgwCABundleDataFile   = "ca-bundle.pem"
+)
+
+const (
+	// radosNamespaceKind and omapConfigKind both describe a pool/namespace where ceph-csi
+	// keeps its RADOS-omap based volume-name-to-image journal, replacing the legacy
+	// ConfigMap-based metadata used by older ceph-csi releases
+	radosNamespaceKind = "RadosNamespace"
+	omapConfigKind     = "OMapConfig"
+
+	// metadataStorageKey signals, on a RadosNamespace/OMapConfig resource, whether ceph-csi
+	// should be driven to store its metadata in RADOS omaps instead of ConfigMaps
+	metadataStorageKey      = "metadataStorage"
+	metadataStorageRadosVal = "rados"
+
+	radosNamespaceParamKey = "csi.storage.k8s.io/rados-namespace"
+	poolParamKey           = "pool"
+	journalPoolParamKey    = "journalPool"
+	fsNameParamKey         = "fsName"
+	namespaceDataKey       = "namespace"
+)
+
 const (
 	rookCephOperatorConfigName = "rook-ceph-operator-config"
 	rookEnableCephFSCSIKey     = "ROOK_CSI_ENABLE_CEPHFS"
 )
 
+// externalResourceCallTimeout bounds each apiserver round-trip made in this file (reading the
+// external cluster details Secret, reconciling each ConfigMap/Secret, and reading/updating the
+// rook operator config), so a slow apiserver during install doesn't wedge the reconcile queue.
+// StorageClass creation goes through createStorageClasses, which takes no context and is not
+// bounded by it.
+const externalResourceCallTimeout = 30 * time.Second
+
+// callWithTimeout derives an externalResourceCallTimeout-bounded context from ctx, runs fn, and
+// releases the context before returning
+func callWithTimeout(ctx context.Context, fn func(context.Context) error) error {
+	callCtx, cancel := context.WithTimeout(ctx, externalResourceCallTimeout)
+	defer cancel()
+	return fn(callCtx)
+}
+
+const (
+	// these are the CSI user Secrets whose caps are required to carry the OSD blocklist
+	// permission so that VolumeReplication/RBD-mirror network fencing works
+	rookCSIRBDProvisionerSecretName    = "rook-csi-rbd-provisioner"
+	rookCSIRBDNodeSecretName           = "rook-csi-rbd-node"
+	rookCSICephFSProvisionerSecretName = "rook-csi-cephfs-provisioner"
+	rookCSICephFSNodeSecretName        = "rook-csi-cephfs-node"
+
+	// cephKeyringCapsDataKey is the Secret data key carrying the user's ceph keyring caps,
+	// e.g. `[mon] profile simple-rados-client-with-blocklist\n[osd] allow rwx pool=foo, allow command osd blocklist`
+	cephKeyringCapsDataKey = "caps"
+
+	monBlocklistCapProfile = "profile simple-rados-client-with-blocklist"
+	osdBlocklistCap        = "allow command osd blocklist"
+
+	// externalCredentialsInsufficientReason is set on the StorageCluster when an external CSI
+	// user Secret is missing the caps required for Metro-DR / RBD-mirror fencing
+	externalCredentialsInsufficientReason = "ExternalCredentialsInsufficient"
+)
+
+var externalCredentialsInsufficientConditionType = conditionsv1.ConditionType(externalCredentialsInsufficientReason)
+
 var (
 	// externalRgwEndpoint is the rgw endpoint as discovered in the Secret externalClusterDetailsSecret
 	// It is used for independent mode only. It will be passed to the Noobaa CR as a label
 	externalRgwEndpoint string
 )
 
-// ExternalResource containes a list of External Cluster Resources
-type ExternalResource struct {
-	Kind string            `json:"kind"`
-	Data map[string]string `json:"data"`
-	Name string            `json:"name"`
-}
+// ExternalResource is an alias of external.ExternalResource, kept so the rest of this file
+// doesn't need to change now that decoding lives in pkg/external
+type ExternalResource = external.ExternalResource
 
 // setRookCSICephFS function enables or disables the 'ROOK_CSI_ENABLE_CEPHFS' key
 func (r *ReconcileStorageCluster) setRookCSICephFS(
-	enableDisableFlag bool, instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	ctx context.Context, enableDisableFlag bool, instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
 	rookCephOperatorConfig := &corev1.ConfigMap{}
-	err := r.client.Get(context.TODO(),
-		types.NamespacedName{Name: rookCephOperatorConfigName, Namespace: instance.ObjectMeta.Namespace},
-		rookCephOperatorConfig)
+	err := callWithTimeout(ctx, func(callCtx context.Context) error {
+		return r.client.Get(callCtx,
+			types.NamespacedName{Name: rookCephOperatorConfigName, Namespace: instance.ObjectMeta.Namespace},
+			rookCephOperatorConfig)
+	})
 	if err != nil {
 		reqLogger.Error(err, fmt.Sprintf("Unable to get '%s' config", rookCephOperatorConfigName))
 		return err
@@ -59,44 +138,62 @@ func (r *ReconcileStorageCluster) setRookCSICephFS(
 		return nil
 	}
 	rookCephOperatorConfig.Data[rookEnableCephFSCSIKey] = enableDisableFlagStr
-	return r.client.Update(context.TODO(), rookCephOperatorConfig)
+	return callWithTimeout(ctx, func(callCtx context.Context) error {
+		return r.client.Update(callCtx, rookCephOperatorConfig)
+	})
 }
 
-// ensureExternalStorageClusterResources ensures that requested resources for the external cluster
-// being created
-func (r *ReconcileStorageCluster) ensureExternalStorageClusterResources(instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
-	// check for the status boolean value accepted or not
-	if instance.Status.ExternalSecretFound {
-		return nil
-	}
+// ensureExternalStorageClusterResources reconciles the resources requested by the external
+// cluster on every pass, rather than only the first time the Secret is found, so that edits
+// to externalClusterDetailsSecret (rotated keyrings, new blocklist caps, a changed rgw
+// endpoint, MDS toggled on/off) converge without deleting the StorageCluster. There is no
+// watch on externalClusterDetailsSecret, so an edit to it only takes effect on the next
+// StorageCluster reconcile triggered by some other event (periodic resync, a StorageCluster
+// update, etc.) rather than immediately. Registering a watch on externalClusterDetailsSecret
+// (a Watch() plus an EnqueueRequestsFromMapFunc mapping the Secret back to its StorageCluster)
+// would close that gap, but that wiring belongs in this controller's SetupWithManager, which is
+// out of scope here: this function only reconciles resources already read from the Secret, it
+// does not register what triggers the reconcile.
+func (r *ReconcileStorageCluster) ensureExternalStorageClusterResources(ctx context.Context, instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	getCtx, cancel := context.WithTimeout(ctx, externalResourceCallTimeout)
+	defer cancel()
 	found := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      externalClusterDetailsSecret,
 			Namespace: instance.Namespace,
 		},
 	}
-	err := r.client.Get(context.TODO(), types.NamespacedName{Name: found.Name, Namespace: found.Namespace}, found)
+	err := r.client.Get(getCtx, types.NamespacedName{Name: found.Name, Namespace: found.Namespace}, found)
 	if err != nil {
 		return err
 	}
-	var data []ExternalResource
-	err = json.Unmarshal(found.Data[externalClusterDetailsKey], &data)
+	info, err := external.Decode(found.Data[externalClusterDetailsKey])
 	if err != nil {
-		reqLogger.Error(err, "could not parse json blob")
+		reqLogger.Error(err, "could not decode external cluster details")
 		return err
 	}
-	err = r.createExternalStorageClusterResources(data, instance, reqLogger)
+	credentialsInsufficient, err := r.createExternalStorageClusterResources(ctx, info, instance, reqLogger)
 	if err != nil {
 		reqLogger.Error(err, "could not create ExternalStorageClusterResource")
 		return err
 	}
-	instance.Status.ExternalSecretFound = true
+	// an external CSI user Secret missing its blocklist caps degrades fencing for that user but
+	// should not be reported as a fully reconciled external cluster
+	instance.Status.ExternalSecretFound = !credentialsInsufficient
 	return nil
 }
 
-// createExternalStorageClusterResources create the needed external cluster resources
+// createExternalStorageClusterResources create the needed external cluster resources. Resources
+// are applied kind-by-kind, in a fixed order, via info's typed accessors rather than scanning the
+// raw resource list by hand: ConfigMaps and Secrets first, then the base StorageClass parameters,
+// then the RadosNamespace/OMapConfig and CephObjectStore overlays that refine those StorageClasses
+// (and the legacy single rgw endpoint) - so a newer, richer resource always wins regardless of
+// where it appears in the external_cluster_details payload.
 func (r *ReconcileStorageCluster) createExternalStorageClusterResources(
-	data []ExternalResource, instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	ctx context.Context, info *external.ExternalClusterInfo, instance *ocsv1.StorageCluster, reqLogger logr.Logger) (credentialsInsufficient bool, err error) {
+	if info.CephVersion != "" {
+		reqLogger.Info(fmt.Sprintf("external cluster details reports ceph version %q", info.CephVersion))
+	}
 	ownerRef := metav1.OwnerReference{
 		UID:        instance.UID,
 		APIVersion: instance.APIVersion,
@@ -106,119 +203,387 @@ func (r *ReconcileStorageCluster) createExternalStorageClusterResources(
 	scs, err := r.newStorageClasses(instance)
 	if err != nil {
 		reqLogger.Error(err, "failed to create StorageClasses")
-		return err
+		return false, err
 	}
 	// this flag sets the 'ROOK_CSI_ENABLE_CEPHFS' flag
 	enableRookCSICephFS := false
 	// this stores only the StorageClasses specified in the Secret
 	var availableSCs []*storagev1.StorageClass
-	for _, d := range data {
-		objectMeta := metav1.ObjectMeta{
-			Name:            d.Name,
-			Namespace:       instance.Namespace,
-			OwnerReferences: []metav1.OwnerReference{ownerRef},
+
+	// ceph-csi is moving away from ConfigMap based journal metadata towards stateless RADOS
+	// omaps. The capabilities list is the authoritative signal for this, since (unlike scanning
+	// the RadosNamespace/OMapConfig resources) it doesn't depend on where that resource falls in
+	// the payload; fall back to scanning the resources for older external clusters that predate
+	// the capability being advertised.
+	useRadosMetadataStorage := info.HasCapability(metadataStorageRadosVal)
+	if !useRadosMetadataStorage {
+		for _, d := range append(info.GetResourcesByKind(radosNamespaceKind), info.GetResourcesByKind(omapConfigKind)...) {
+			if d.Data[metadataStorageKey] == metadataStorageRadosVal {
+				useRadosMetadataStorage = true
+				break
+			}
+		}
+	}
+
+	for _, d := range info.GetResourcesByKind("ConfigMap") {
+		if err := ctx.Err(); err != nil {
+			return credentialsInsufficient, err
+		}
+		if useRadosMetadataStorage {
+			// the external cluster is driving ceph-csi with RADOS-omap based metadata,
+			// so the legacy CSI ConfigMaps are unused and must not be created
+			reqLogger.Info(fmt.Sprintf("skipping configmap %q: cluster uses RADOS-omap metadata storage", d.Name))
+			continue
 		}
+		cmData := d.Data
+		if len(info.CSIConfig) > 0 {
+			cmData = mergeStringMaps(info.CSIConfig, d.Data)
+		}
+		cm := &corev1.ConfigMap{ObjectMeta: ownedObjectMeta(d.Name, instance.Namespace, ownerRef), Data: cmData}
+		found := &corev1.ConfigMap{ObjectMeta: cm.ObjectMeta}
 		objectKey := types.NamespacedName{Name: d.Name, Namespace: instance.Namespace}
-		switch d.Kind {
-		case "ConfigMap":
-			cm := &corev1.ConfigMap{
-				ObjectMeta: objectMeta,
-				Data:       d.Data,
-			}
-			found := &corev1.ConfigMap{ObjectMeta: objectMeta}
-			err := r.createExternalStorageClusterConfigMap(cm, found, reqLogger, objectKey)
-			if err != nil {
-				reqLogger.Error(err, "could not create ExternalStorageClusterConfigMap")
-				return err
-			}
-		case "Secret":
-			sec := &corev1.Secret{
-				ObjectMeta: objectMeta,
-				Data:       make(map[string][]byte),
-			}
-			for k, v := range d.Data {
-				sec.Data[k] = []byte(v)
-			}
-			found := &corev1.Secret{ObjectMeta: objectMeta}
-			err := r.createExternalStorageClusterSecret(sec, found, reqLogger, objectKey)
-			if err != nil {
-				reqLogger.Error(err, "could not create ExternalStorageClusterSecret")
-				return err
-			}
-		case "StorageClass":
-			var sc *storagev1.StorageClass
-			if d.Name == cephFsStorageClassName {
-				// 'sc' points to CephFS StorageClass
-				sc = scs[0]
-				enableRookCSICephFS = true
-			} else if d.Name == cephRbdStorageClassName {
-				// 'sc' points to RBD StorageClass
-				sc = scs[1]
-			} else if d.Name == cephRgwStorageClassName {
-				// Set the external rgw endpoint variable for later use on the Noobaa CR (as a label)
-				// Replace the colon with an underscore, otherwise the label will be invalid
-				externalRgwEndpointReplaceColon := strings.Replace(d.Data[externalCephRgwEndpointKey], ":", "_", -1)
-				externalRgwEndpoint = externalRgwEndpointReplaceColon
-
-				// 'sc' points to OBC StorageClass
-				sc = scs[2]
-			}
-			// now sc is pointing to appropriate StorageClass,
-			// whose parameters have to be updated
-			for k, v := range d.Data {
-				sc.Parameters[k] = v
+		err := callWithTimeout(ctx, func(callCtx context.Context) error {
+			return r.createExternalStorageClusterConfigMap(callCtx, cm, found, reqLogger, objectKey)
+		})
+		if err != nil {
+			reqLogger.Error(err, "could not create ExternalStorageClusterConfigMap")
+			return credentialsInsufficient, err
+		}
+	}
+
+	// A CSI user Secret that is missing the blocklist caps degrades one feature
+	// (VolumeReplication/RBD-mirror fencing won't work for it) rather than basic provisioning,
+	// which still needs this same Secret - so it is still created, and the insufficiency is only
+	// surfaced via externalCredentialsInsufficientConditionType and by refusing to mark
+	// instance.Status.ExternalSecretFound in the caller, rather than aborting the rest of this
+	// reconcile. Without this, every pre-existing external cluster bootstrapped before blocklist
+	// caps existed would stop reconciling entirely the moment this check shipped.
+	for _, d := range info.GetResourcesByKind("Secret") {
+		if err := ctx.Err(); err != nil {
+			return credentialsInsufficient, err
+		}
+		if isExternalCSIUserSecret(d.Name) {
+			if missing := missingBlocklistCaps(d); len(missing) > 0 {
+				msg := fmt.Sprintf("external user %q is missing required caps: %s", d.Name, strings.Join(missing, ", "))
+				reqLogger.Error(fmt.Errorf(msg), "insufficient external credentials")
+				r.setExternalCredentialsInsufficientCondition(instance, msg)
+				credentialsInsufficient = true
 			}
-			availableSCs = append(availableSCs, sc)
+		}
+		sec := &corev1.Secret{ObjectMeta: ownedObjectMeta(d.Name, instance.Namespace, ownerRef), Data: make(map[string][]byte)}
+		for k, v := range d.Data {
+			sec.Data[k] = []byte(v)
+		}
+		found := &corev1.Secret{ObjectMeta: sec.ObjectMeta}
+		objectKey := types.NamespacedName{Name: d.Name, Namespace: instance.Namespace}
+		err := callWithTimeout(ctx, func(callCtx context.Context) error {
+			return r.createExternalStorageClusterSecret(callCtx, sec, found, reqLogger, objectKey)
+		})
+		if err != nil {
+			reqLogger.Error(err, "could not create ExternalStorageClusterSecret")
+			return credentialsInsufficient, err
+		}
+	}
+	if !credentialsInsufficient {
+		r.clearExternalCredentialsInsufficientCondition(instance)
+	}
+
+	for _, d := range info.GetResourcesByKind("StorageClass") {
+		if err := ctx.Err(); err != nil {
+			return credentialsInsufficient, err
+		}
+		var sc *storagev1.StorageClass
+		if d.Name == cephFsStorageClassName {
+			// 'sc' points to CephFS StorageClass
+			sc = scs[0]
+			enableRookCSICephFS = true
+		} else if d.Name == cephRbdStorageClassName {
+			// 'sc' points to RBD StorageClass
+			sc = scs[1]
+		} else if d.Name == cephRgwStorageClassName {
+			// Set the external rgw endpoint variable for later use on the Noobaa CR (as a label).
+			// Replace the colon with an underscore, otherwise the label will be invalid. A
+			// cephObjectStoreKind resource, applied below, overrides this with the richer
+			// multi-endpoint/TLS configuration when the external cluster provides one.
+			externalRgwEndpoint = strings.Replace(d.Data[externalCephRgwEndpointKey], ":", "_", -1)
+
+			// 'sc' points to OBC StorageClass
+			sc = scs[2]
+		}
+		// now sc is pointing to appropriate StorageClass,
+		// whose parameters have to be updated
+		for k, v := range d.Data {
+			sc.Parameters[k] = v
+		}
+		availableSCs = append(availableSCs, sc)
+	}
+
+	// applied after the base StorageClass parameters so the RADOS-omap journal location always
+	// wins over whatever the legacy StorageClass resources may also carry for the same keys
+	for _, d := range append(info.GetResourcesByKind(radosNamespaceKind), info.GetResourcesByKind(omapConfigKind)...) {
+		if err := ctx.Err(); err != nil {
+			return credentialsInsufficient, err
+		}
+		// translate the RADOS-omap journal location into StorageClass parameters so
+		// ceph-csi provisioners can find the pool/namespace without a ConfigMap
+		if pool, ok := d.Data[poolParamKey]; ok {
+			scs[1].Parameters[poolParamKey] = pool
+		}
+		if journalPool, ok := d.Data[journalPoolParamKey]; ok {
+			scs[1].Parameters[journalPoolParamKey] = journalPool
+		}
+		if fsName, ok := d.Data[fsNameParamKey]; ok {
+			scs[0].Parameters[fsNameParamKey] = fsName
+		}
+		if namespace, ok := d.Data[namespaceDataKey]; ok {
+			scs[0].Parameters[radosNamespaceParamKey] = namespace
+			scs[1].Parameters[radosNamespaceParamKey] = namespace
+		}
+	}
+
+	// applied after the legacy "StorageClass"/ceph-rgw handling above so a CephObjectStore
+	// resource always wins the externalRgwEndpoint value, regardless of resource ordering
+	for _, d := range info.GetResourcesByKind(cephObjectStoreKind) {
+		if err := ctx.Err(); err != nil {
+			return credentialsInsufficient, err
+		}
+		err := callWithTimeout(ctx, func(callCtx context.Context) error {
+			return r.createExternalRgwEndpointsResources(callCtx, d, instance, ownerRef, reqLogger)
+		})
+		if err != nil {
+			reqLogger.Error(err, "could not reconcile external RGW endpoints")
+			return credentialsInsufficient, err
 		}
 	}
+
 	// creating only the available storageClasses
 	err = r.createStorageClasses(availableSCs, reqLogger)
 	if err != nil {
 		reqLogger.Error(err, "failed to create needed StorageClasses")
-		return err
+		return credentialsInsufficient, err
 	}
-	if err = r.setRookCSICephFS(enableRookCSICephFS, instance, reqLogger); err != nil {
+	if err = r.setRookCSICephFS(ctx, enableRookCSICephFS, instance, reqLogger); err != nil {
 		reqLogger.Error(err,
 			fmt.Sprintf("failed to set '%s' to %v", rookEnableCephFSCSIKey, enableRookCSICephFS))
+		return credentialsInsufficient, err
+	}
+	return credentialsInsufficient, nil
+}
+
+// ownedObjectMeta builds the ObjectMeta shared by every resource this file materializes on
+// behalf of the external cluster.
+func ownedObjectMeta(name, namespace string, ownerRef metav1.OwnerReference) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       namespace,
+		OwnerReferences: []metav1.OwnerReference{ownerRef},
+	}
+}
+
+// mergeStringMaps returns a new map containing base overlaid with override, without mutating
+// either input.
+func mergeStringMaps(base, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// createExternalRgwEndpointsResources reconciles the external RGW endpoints and, if the
+// external cluster provided one, the CA bundle Noobaa needs to trust a TLS-fronted RGW.
+// Unlike the old single-endpoint label, which is still set below from the first endpoint, these
+// can express several endpoints behind a load balancer and a private CA.
+func (r *ReconcileStorageCluster) createExternalRgwEndpointsResources(
+	ctx context.Context, d ExternalResource, instance *ocsv1.StorageCluster, ownerRef metav1.OwnerReference, reqLogger logr.Logger) error {
+	var endpoints []string
+	for _, endpoint := range strings.Split(d.Data[externalRgwEndpointsDataKey], ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	tlsEnabled, _ := strconv.ParseBool(d.Data[externalRgwTLSDataKey])
+
+	cmObjectMeta := metav1.ObjectMeta{
+		Name:            externalRgwEndpointsConfigMapName,
+		Namespace:       instance.Namespace,
+		OwnerReferences: []metav1.OwnerReference{ownerRef},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: cmObjectMeta,
+		Data: map[string]string{
+			externalRgwEndpointsDataKey: strings.Join(endpoints, ","),
+			externalRgwTLSDataKey:       strconv.FormatBool(tlsEnabled),
+		},
+	}
+	found := &corev1.ConfigMap{ObjectMeta: cmObjectMeta}
+	objectKey := types.NamespacedName{Name: cmObjectMeta.Name, Namespace: cmObjectMeta.Namespace}
+	if err := r.createExternalStorageClusterConfigMap(ctx, cm, found, reqLogger, objectKey); err != nil {
 		return err
 	}
+
+	if caBundle := d.Data[externalRgwCABundleDataKey]; caBundle != "" {
+		secObjectMeta := metav1.ObjectMeta{
+			Name:            externalRgwCABundleSecretName,
+			Namespace:       instance.Namespace,
+			OwnerReferences: []metav1.OwnerReference{ownerRef},
+		}
+		sec := &corev1.Secret{
+			ObjectMeta: secObjectMeta,
+			Data:       map[string][]byte{externalRgwCABundleDataFile: []byte(caBundle)},
+		}
+		foundSec := &corev1.Secret{ObjectMeta: secObjectMeta}
+		secObjectKey := types.NamespacedName{Name: secObjectMeta.Name, Namespace: secObjectMeta.Namespace}
+		if err := r.createExternalStorageClusterSecret(ctx, sec, foundSec, reqLogger, secObjectKey); err != nil {
+			return err
+		}
+	}
+
+	// keep the legacy single-endpoint variable populated too, for callers that have not yet
+	// moved to RgwEndpointsForNoobaa
+	if len(endpoints) > 0 {
+		externalRgwEndpoint = strings.Replace(endpoints[0], ":", "_", -1)
+	}
 	return nil
 }
 
-// createExternalStorageClusterConfigMap creates configmap for external cluster
-func (r *ReconcileStorageCluster) createExternalStorageClusterConfigMap(cm *corev1.ConfigMap, found *corev1.ConfigMap, reqLogger logr.Logger, objectKey types.NamespacedName) error {
-	err := r.client.Get(context.TODO(), objectKey, found)
+// RgwEndpointsForNoobaa returns the RGW endpoints and, if the external cluster provided one, the
+// CA bundle, read back from the ConfigMap/Secret createExternalRgwEndpointsResources maintains.
+// It is meant to let the Noobaa CR reconciler consume multiple endpoints and a private CA instead
+// of the legacy externalRgwEndpoint single-endpoint global, and is safe to call even when the
+// external cluster hasn't requested an RGW StorageClass (both return values come back
+// empty/nil). The Noobaa CR reconciler does not live in this package and is not touched by this
+// change, so this method has no caller yet; it is added here, next to the resources it reads, so
+// that reconciler can be wired up to it without another pass over this file.
+func (r *ReconcileStorageCluster) RgwEndpointsForNoobaa(ctx context.Context, namespace string) (endpoints []string, caBundle []byte, err error) {
+	cm := &corev1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: externalRgwEndpointsConfigMapName, Namespace: namespace}
+	getErr := callWithTimeout(ctx, func(callCtx context.Context) error {
+		return r.client.Get(callCtx, cmKey, cm)
+	})
+	if getErr != nil {
+		if errors.IsNotFound(getErr) {
+			return nil, nil, nil
+		}
+		return nil, nil, getErr
+	}
+	for _, endpoint := range strings.Split(cm.Data[externalRgwEndpointsDataKey], ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	sec := &corev1.Secret{}
+	secKey := types.NamespacedName{Name: externalRgwCABundleSecretName, Namespace: namespace}
+	getErr = callWithTimeout(ctx, func(callCtx context.Context) error {
+		return r.client.Get(callCtx, secKey, sec)
+	})
+	if getErr != nil {
+		if errors.IsNotFound(getErr) {
+			return endpoints, nil, nil
+		}
+		return endpoints, nil, getErr
+	}
+	return endpoints, sec.Data[externalRgwCABundleDataFile], nil
+}
+
+// isExternalCSIUserSecret returns true if name identifies one of the ceph-csi provisioner/node
+// user Secrets shipped by the external cluster details script
+func isExternalCSIUserSecret(name string) bool {
+	switch name {
+	case rookCSIRBDProvisionerSecretName, rookCSIRBDNodeSecretName,
+		rookCSICephFSProvisionerSecretName, rookCSICephFSNodeSecretName:
+		return true
+	}
+	return false
+}
+
+// missingBlocklistCaps inspects the keyring caps embedded in a CSI user Secret's data and
+// returns the caps, if any, that are required for OSD blocklist based fencing but absent
+func missingBlocklistCaps(d ExternalResource) []string {
+	var missing []string
+	caps := d.Data[cephKeyringCapsDataKey]
+	if !strings.Contains(caps, monBlocklistCapProfile) {
+		missing = append(missing, monBlocklistCapProfile)
+	}
+	if !strings.Contains(caps, osdBlocklistCap) {
+		missing = append(missing, osdBlocklistCap)
+	}
+	return missing
+}
+
+// setExternalCredentialsInsufficientCondition records that an external CSI user Secret is
+// missing the caps required for VolumeReplication/RBD-mirror network fencing
+func (r *ReconcileStorageCluster) setExternalCredentialsInsufficientCondition(instance *ocsv1.StorageCluster, message string) {
+	conditionsv1.SetStatusCondition(&instance.Status.Conditions, conditionsv1.Condition{
+		Type:    externalCredentialsInsufficientConditionType,
+		Status:  corev1.ConditionTrue,
+		Reason:  externalCredentialsInsufficientReason,
+		Message: message,
+	})
+}
+
+// clearExternalCredentialsInsufficientCondition records that every external CSI user Secret
+// currently has the caps required for VolumeReplication/RBD-mirror network fencing, resolving
+// any previously surfaced externalCredentialsInsufficientConditionType
+func (r *ReconcileStorageCluster) clearExternalCredentialsInsufficientCondition(instance *ocsv1.StorageCluster) {
+	conditionsv1.SetStatusCondition(&instance.Status.Conditions, conditionsv1.Condition{
+		Type:    externalCredentialsInsufficientConditionType,
+		Status:  corev1.ConditionFalse,
+		Reason:  externalCredentialsInsufficientReason,
+		Message: "all external CSI user secrets have the required blocklist caps",
+	})
+}
+
+// createExternalStorageClusterConfigMap reconciles the configmap for the external cluster,
+// creating it if absent and updating its Data if it has drifted from the desired state
+func (r *ReconcileStorageCluster) createExternalStorageClusterConfigMap(ctx context.Context, cm *corev1.ConfigMap, found *corev1.ConfigMap, reqLogger logr.Logger, objectKey types.NamespacedName) error {
+	err := r.client.Get(ctx, objectKey, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			reqLogger.Info(fmt.Sprintf("creating configmap: %s", cm.Name))
-			err = r.client.Create(context.TODO(), cm)
-			if err != nil {
-				reqLogger.Error(err, "creation of configmap failed")
-				return err
-			}
-		} else {
-			reqLogger.Error(err, "unable the get the configmap")
-			return err
+			return r.client.Create(ctx, cm)
 		}
+		reqLogger.Error(err, "unable to get the configmap")
+		return err
 	}
-	return nil
+	if reflect.DeepEqual(found.Data, cm.Data) {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.client.Get(ctx, objectKey, found); err != nil {
+			return err
+		}
+		found.Data = cm.Data
+		reqLogger.Info(fmt.Sprintf("updating configmap: %s", cm.Name))
+		return r.client.Update(ctx, found)
+	})
 }
 
-// createExternalStorageClusterSecret creates secret for external cluster
-func (r *ReconcileStorageCluster) createExternalStorageClusterSecret(sec *corev1.Secret, found *corev1.Secret, reqLogger logr.Logger, objectKey types.NamespacedName) error {
-	err := r.client.Get(context.TODO(), objectKey, found)
+// createExternalStorageClusterSecret reconciles the secret for the external cluster,
+// creating it if absent and updating its Data if it has drifted from the desired state
+func (r *ReconcileStorageCluster) createExternalStorageClusterSecret(ctx context.Context, sec *corev1.Secret, found *corev1.Secret, reqLogger logr.Logger, objectKey types.NamespacedName) error {
+	err := r.client.Get(ctx, objectKey, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			reqLogger.Info(fmt.Sprintf("creating secret: %s", sec.Name))
-			err = r.client.Create(context.TODO(), sec)
-			if err != nil {
-				reqLogger.Error(err, "creation of secret failed")
-				return err
-			}
-		} else {
-			reqLogger.Error(err, "unable the get the secret")
-			return err
+			return r.client.Create(ctx, sec)
 		}
+		reqLogger.Error(err, "unable to get the secret")
+		return err
 	}
-	return nil
+	if reflect.DeepEqual(found.Data, sec.Data) {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.client.Get(ctx, objectKey, found); err != nil {
+			return err
+		}
+		found.Data = sec.Data
+		reqLogger.Info(fmt.Sprintf("updating secret: %s", sec.Name))
+		return r.client.Update(ctx, found)
+	})
 }
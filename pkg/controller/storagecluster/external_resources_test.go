@@ -0,0 +1,350 @@
+package storagecluster
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	logrtesting "github.com/go-logr/logr/testing"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/external"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestCreateExternalStorageClusterConfigMapConverges verifies that re-running the ConfigMap
+// reconcile after the external-cluster-details secret changes picks up the new Data instead
+// of leaving the first-created ConfigMap untouched.
+func TestCreateExternalStorageClusterConfigMapConverges(t *testing.T) {
+	reqLogger := logrtesting.NullLogger{}
+	objectKey := types.NamespacedName{Name: "rook-ceph-csi-config", Namespace: "openshift-storage"}
+	objectMeta := metav1.ObjectMeta{Name: objectKey.Name, Namespace: objectKey.Namespace}
+
+	cm := &corev1.ConfigMap{ObjectMeta: objectMeta, Data: map[string]string{"csi-cluster-config-json": "v1"}}
+	fakeClient := fake.NewFakeClient(cm.DeepCopy())
+	reconciler := &ReconcileStorageCluster{client: fakeClient}
+
+	found := &corev1.ConfigMap{ObjectMeta: objectMeta}
+	if err := reconciler.createExternalStorageClusterConfigMap(context.TODO(), cm, found, reqLogger, objectKey); err != nil {
+		t.Fatalf("unexpected error on no-op reconcile: %v", err)
+	}
+
+	// the external cluster rotated its config; the next reconcile should update in place
+	cm.Data = map[string]string{"csi-cluster-config-json": "v2"}
+	if err := reconciler.createExternalStorageClusterConfigMap(context.TODO(), cm, found, reqLogger, objectKey); err != nil {
+		t.Fatalf("unexpected error reconciling drifted configmap: %v", err)
+	}
+
+	updated := &corev1.ConfigMap{}
+	if err := fakeClient.Get(context.TODO(), objectKey, updated); err != nil {
+		t.Fatalf("unexpected error fetching configmap: %v", err)
+	}
+	if updated.Data["csi-cluster-config-json"] != "v2" {
+		t.Errorf("expected configmap to converge to v2, got %q", updated.Data["csi-cluster-config-json"])
+	}
+}
+
+// TestCreateExternalStorageClusterSecretConverges verifies that a rotated external CSI user
+// secret is reflected onto the cluster-local Secret on the next reconcile.
+func TestCreateExternalStorageClusterSecretConverges(t *testing.T) {
+	reqLogger := logrtesting.NullLogger{}
+	objectKey := types.NamespacedName{Name: rookCSIRBDNodeSecretName, Namespace: "openshift-storage"}
+	objectMeta := metav1.ObjectMeta{Name: objectKey.Name, Namespace: objectKey.Namespace}
+
+	sec := &corev1.Secret{ObjectMeta: objectMeta, Data: map[string][]byte{"userKey": []byte("old-key")}}
+	fakeClient := fake.NewFakeClient(sec.DeepCopy())
+	reconciler := &ReconcileStorageCluster{client: fakeClient}
+
+	found := &corev1.Secret{ObjectMeta: objectMeta}
+	if err := reconciler.createExternalStorageClusterSecret(context.TODO(), sec, found, reqLogger, objectKey); err != nil {
+		t.Fatalf("unexpected error on no-op reconcile: %v", err)
+	}
+
+	sec.Data = map[string][]byte{"userKey": []byte("rotated-key")}
+	if err := reconciler.createExternalStorageClusterSecret(context.TODO(), sec, found, reqLogger, objectKey); err != nil {
+		t.Fatalf("unexpected error reconciling rotated secret: %v", err)
+	}
+
+	updated := &corev1.Secret{}
+	if err := fakeClient.Get(context.TODO(), objectKey, updated); err != nil {
+		t.Fatalf("unexpected error fetching secret: %v", err)
+	}
+	if string(updated.Data["userKey"]) != "rotated-key" {
+		t.Errorf("expected secret to converge to rotated-key, got %q", updated.Data["userKey"])
+	}
+}
+
+// externalClusterDetailsSecretWith builds the rook-ceph-external-cluster-details Secret a
+// versioned ExternalClusterInfo would be shipped in.
+func externalClusterDetailsSecretWith(namespace string, resources []external.ExternalResource) *corev1.Secret {
+	info := &external.ExternalClusterInfo{APIVersion: external.CurrentAPIVersion, Resources: resources}
+	data, err := json.Marshal(info)
+	if err != nil {
+		panic(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: externalClusterDetailsSecret, Namespace: namespace},
+		Data:       map[string][]byte{externalClusterDetailsKey: data},
+	}
+}
+
+// rookCephOperatorConfigIn builds the rook-ceph-operator-config ConfigMap that setRookCSICephFS
+// reads and updates at the end of every external reconcile.
+func rookCephOperatorConfigIn(namespace string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: rookCephOperatorConfigName, Namespace: namespace},
+		Data:       map[string]string{rookEnableCephFSCSIKey: "false"},
+	}
+}
+
+// findStorageClass returns the StorageClass carrying the given parameter key, so the test
+// doesn't need to guess the naming convention newStorageClasses uses.
+func findStorageClass(t *testing.T, fakeClient client.Client, paramKey string) *storagev1.StorageClass {
+	t.Helper()
+	list := &storagev1.StorageClassList{}
+	if err := fakeClient.List(context.TODO(), nil, list); err != nil {
+		t.Fatalf("unexpected error listing storageclasses: %v", err)
+	}
+	for i := range list.Items {
+		if _, ok := list.Items[i].Parameters[paramKey]; ok {
+			return &list.Items[i]
+		}
+	}
+	t.Fatalf("no storageclass found with parameter %q", paramKey)
+	return nil
+}
+
+// TestEnsureExternalStorageClusterResourcesConverges verifies that re-running
+// ensureExternalStorageClusterResources after the external-cluster-details secret changes
+// updates both the CSI ConfigMap and the RBD StorageClass's RADOS-omap parameters, and that a
+// RadosNamespace resource listed after the legacy "StorageClass" resource wins for the same
+// parameter keys.
+func TestEnsureExternalStorageClusterResourcesConverges(t *testing.T) {
+	reqLogger := logrtesting.NullLogger{}
+	instance := &ocsv1.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-storagecluster", Namespace: "openshift-storage"},
+	}
+
+	resources := []external.ExternalResource{
+		{Kind: "ConfigMap", Name: "rook-ceph-csi-config", Data: map[string]string{"csi-cluster-config-json": "v1"}},
+		{Kind: "StorageClass", Name: cephRbdStorageClassName, Data: map[string]string{poolParamKey: "legacy-pool"}},
+		{Kind: radosNamespaceKind, Name: "rbd-rados-namespace", Data: map[string]string{
+			poolParamKey:     "rados-pool-v1",
+			namespaceDataKey: "rados-ns-v1",
+		}},
+	}
+	secret := externalClusterDetailsSecretWith(instance.Namespace, resources)
+	fakeClient := fake.NewFakeClient(secret, rookCephOperatorConfigIn(instance.Namespace))
+	reconciler := &ReconcileStorageCluster{client: fakeClient}
+
+	if err := reconciler.ensureExternalStorageClusterResources(context.TODO(), instance, reqLogger); err != nil {
+		t.Fatalf("unexpected error on initial reconcile: %v", err)
+	}
+
+	rbdSC := findStorageClass(t, fakeClient, poolParamKey)
+	if rbdSC.Parameters[poolParamKey] != "rados-pool-v1" {
+		t.Errorf("expected RadosNamespace pool to win over legacy StorageClass pool, got %q", rbdSC.Parameters[poolParamKey])
+	}
+	if rbdSC.Parameters[radosNamespaceParamKey] != "rados-ns-v1" {
+		t.Errorf("expected rados-namespace parameter to be set, got %q", rbdSC.Parameters[radosNamespaceParamKey])
+	}
+
+	cm := &corev1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: "rook-ceph-csi-config", Namespace: instance.Namespace}
+	if err := fakeClient.Get(context.TODO(), cmKey, cm); err != nil {
+		t.Fatalf("unexpected error fetching configmap: %v", err)
+	}
+	if cm.Data["csi-cluster-config-json"] != "v1" {
+		t.Errorf("expected configmap to start at v1, got %q", cm.Data["csi-cluster-config-json"])
+	}
+
+	// the external cluster rotates the pool and the csi config; a second reconcile should
+	// converge both without needing to delete the StorageCluster
+	resources[0].Data["csi-cluster-config-json"] = "v2"
+	resources[2].Data[poolParamKey] = "rados-pool-v2"
+	secret.Data[externalClusterDetailsKey] = marshalResources(t, resources)
+	if err := fakeClient.Update(context.TODO(), secret); err != nil {
+		t.Fatalf("unexpected error updating secret: %v", err)
+	}
+
+	if err := reconciler.ensureExternalStorageClusterResources(context.TODO(), instance, reqLogger); err != nil {
+		t.Fatalf("unexpected error on second reconcile: %v", err)
+	}
+
+	rbdSC = findStorageClass(t, fakeClient, poolParamKey)
+	if rbdSC.Parameters[poolParamKey] != "rados-pool-v2" {
+		t.Errorf("expected pool to converge to rados-pool-v2, got %q", rbdSC.Parameters[poolParamKey])
+	}
+	if err := fakeClient.Get(context.TODO(), cmKey, cm); err != nil {
+		t.Fatalf("unexpected error re-fetching configmap: %v", err)
+	}
+	if cm.Data["csi-cluster-config-json"] != "v2" {
+		t.Errorf("expected configmap to converge to v2, got %q", cm.Data["csi-cluster-config-json"])
+	}
+}
+
+// marshalResources re-encodes the versioned envelope for the second half of the convergence test.
+func marshalResources(t *testing.T, resources []external.ExternalResource) []byte {
+	t.Helper()
+	data, err := json.Marshal(&external.ExternalClusterInfo{APIVersion: external.CurrentAPIVersion, Resources: resources})
+	if err != nil {
+		t.Fatalf("unexpected error marshaling external cluster info: %v", err)
+	}
+	return data
+}
+
+// TestRadosNamespaceParametersWinOverLegacyStorageClass pins the precedence between a
+// RadosNamespace/OMapConfig resource and a legacy "StorageClass" resource that set the same
+// parameter keys: the RADOS values must win regardless of which resource appears first in the
+// external cluster details, not by accident of iteration order over the resource list.
+func TestRadosNamespaceParametersWinOverLegacyStorageClass(t *testing.T) {
+	reqLogger := logrtesting.NullLogger{}
+
+	for _, tc := range []struct {
+		name      string
+		resources []external.ExternalResource
+	}{
+		{
+			name: "RadosNamespace listed after the legacy StorageClass",
+			resources: []external.ExternalResource{
+				{Kind: "StorageClass", Name: cephFsStorageClassName, Data: map[string]string{fsNameParamKey: "legacy-fs"}},
+				{Kind: "StorageClass", Name: cephRbdStorageClassName, Data: map[string]string{poolParamKey: "legacy-pool"}},
+				{Kind: "Secret", Name: rookCSICephFSProvisionerSecretName, Data: map[string]string{"userKey": "abc"}},
+				{Kind: radosNamespaceKind, Name: "rbd-rados-namespace", Data: map[string]string{
+					poolParamKey: "rados-pool", fsNameParamKey: "rados-fs", namespaceDataKey: "rados-ns",
+				}},
+			},
+		},
+		{
+			name: "RadosNamespace listed before the legacy StorageClass",
+			resources: []external.ExternalResource{
+				{Kind: radosNamespaceKind, Name: "rbd-rados-namespace", Data: map[string]string{
+					poolParamKey: "rados-pool", fsNameParamKey: "rados-fs", namespaceDataKey: "rados-ns",
+				}},
+				{Kind: "StorageClass", Name: cephFsStorageClassName, Data: map[string]string{fsNameParamKey: "legacy-fs"}},
+				{Kind: "StorageClass", Name: cephRbdStorageClassName, Data: map[string]string{poolParamKey: "legacy-pool"}},
+				{Kind: "Secret", Name: rookCSICephFSProvisionerSecretName, Data: map[string]string{"userKey": "abc"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			instance := &ocsv1.StorageCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-storagecluster", Namespace: "openshift-storage"},
+			}
+			secret := externalClusterDetailsSecretWith(instance.Namespace, tc.resources)
+			fakeClient := fake.NewFakeClient(secret, rookCephOperatorConfigIn(instance.Namespace))
+			reconciler := &ReconcileStorageCluster{client: fakeClient}
+
+			if err := reconciler.ensureExternalStorageClusterResources(context.TODO(), instance, reqLogger); err != nil {
+				t.Fatalf("unexpected error reconciling: %v", err)
+			}
+
+			rbdSC := findStorageClass(t, fakeClient, poolParamKey)
+			if rbdSC.Parameters[poolParamKey] != "rados-pool" {
+				t.Errorf("expected RADOS pool to win, got %q", rbdSC.Parameters[poolParamKey])
+			}
+			if rbdSC.Parameters[radosNamespaceParamKey] != "rados-ns" {
+				t.Errorf("expected rados-namespace parameter to be set, got %q", rbdSC.Parameters[radosNamespaceParamKey])
+			}
+
+			cephFsSC := findStorageClass(t, fakeClient, fsNameParamKey)
+			if cephFsSC.Parameters[fsNameParamKey] != "rados-fs" {
+				t.Errorf("expected RADOS fsName to win, got %q", cephFsSC.Parameters[fsNameParamKey])
+			}
+		})
+	}
+}
+
+// TestCreateExternalStorageClusterResourcesSkipsConfigMapsForRadosMetadataStorage verifies the
+// ConfigMap suppression driven by a radosNamespaceKind/omapConfigKind resource: when the external
+// cluster advertises RADOS-omap based metadata storage, the legacy CSI ConfigMaps must not be created.
+func TestCreateExternalStorageClusterResourcesSkipsConfigMapsForRadosMetadataStorage(t *testing.T) {
+	reqLogger := logrtesting.NullLogger{}
+	instance := &ocsv1.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-storagecluster", Namespace: "openshift-storage"},
+	}
+	resources := []external.ExternalResource{
+		{Kind: "ConfigMap", Name: "rook-ceph-csi-config", Data: map[string]string{"csi-cluster-config-json": "v1"}},
+		{Kind: omapConfigKind, Name: "rbd-omap-config", Data: map[string]string{
+			metadataStorageKey: metadataStorageRadosVal,
+			poolParamKey:        "rados-pool",
+		}},
+	}
+	secret := externalClusterDetailsSecretWith(instance.Namespace, resources)
+	fakeClient := fake.NewFakeClient(secret, rookCephOperatorConfigIn(instance.Namespace))
+	reconciler := &ReconcileStorageCluster{client: fakeClient}
+
+	if err := reconciler.ensureExternalStorageClusterResources(context.TODO(), instance, reqLogger); err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+
+	cm := &corev1.ConfigMap{}
+	cmKey := types.NamespacedName{Name: "rook-ceph-csi-config", Namespace: instance.Namespace}
+	err := fakeClient.Get(context.TODO(), cmKey, cm)
+	if err == nil {
+		t.Errorf("expected csi configmap to be suppressed when using RADOS-omap metadata storage, but it was created")
+	} else if !apierrors.IsNotFound(err) {
+		t.Errorf("expected a NotFound error, got %v", err)
+	}
+}
+
+// TestMissingBlocklistCapsDegradesGracefully verifies that a CSI user secret missing its
+// blocklist caps surfaces externalCredentialsInsufficientConditionType and withholds
+// Status.ExternalSecretFound without aborting the rest of the reconcile - every other
+// ConfigMap, Secret, and StorageClass parameter must still converge.
+func TestMissingBlocklistCapsDegradesGracefully(t *testing.T) {
+	reqLogger := logrtesting.NullLogger{}
+	instance := &ocsv1.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-storagecluster", Namespace: "openshift-storage"},
+	}
+	resources := []external.ExternalResource{
+		{Kind: "ConfigMap", Name: "rook-ceph-csi-config", Data: map[string]string{"csi-cluster-config-json": "v1"}},
+		{Kind: "StorageClass", Name: cephFsStorageClassName, Data: map[string]string{fsNameParamKey: "myfs"}},
+		{Kind: "Secret", Name: rookCSICephFSProvisionerSecretName, Data: map[string]string{cephKeyringCapsDataKey: "[mon] allow r"}},
+		{Kind: "Secret", Name: rookCSIRBDNodeSecretName, Data: map[string]string{"userKey": "abc"}},
+	}
+	secret := externalClusterDetailsSecretWith(instance.Namespace, resources)
+	fakeClient := fake.NewFakeClient(secret, rookCephOperatorConfigIn(instance.Namespace))
+	reconciler := &ReconcileStorageCluster{client: fakeClient}
+
+	if err := reconciler.ensureExternalStorageClusterResources(context.TODO(), instance, reqLogger); err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+
+	if instance.Status.ExternalSecretFound {
+		t.Errorf("expected ExternalSecretFound to stay false when a CSI user secret is missing blocklist caps")
+	}
+	conditionSet := false
+	for _, c := range instance.Status.Conditions {
+		if c.Type == externalCredentialsInsufficientConditionType && c.Status == corev1.ConditionTrue {
+			conditionSet = true
+		}
+	}
+	if !conditionSet {
+		t.Errorf("expected externalCredentialsInsufficientConditionType to be set")
+	}
+
+	cephFsSC := findStorageClass(t, fakeClient, fsNameParamKey)
+	if cephFsSC.Parameters[fsNameParamKey] != "myfs" {
+		t.Errorf("expected fsName to converge despite the missing-cap secret, got %q", cephFsSC.Parameters[fsNameParamKey])
+	}
+
+	rbdNodeSecret := &corev1.Secret{}
+	rbdNodeKey := types.NamespacedName{Name: rookCSIRBDNodeSecretName, Namespace: instance.Namespace}
+	if err := fakeClient.Get(context.TODO(), rbdNodeKey, rbdNodeSecret); err != nil {
+		t.Fatalf("expected unrelated secret to still be created, got error: %v", err)
+	}
+
+	// the secret with insufficient caps is itself still created: basic CSI provisioning still
+	// needs it even though fencing won't work for it
+	cephFsProvisionerSecret := &corev1.Secret{}
+	cephFsProvisionerKey := types.NamespacedName{Name: rookCSICephFSProvisionerSecretName, Namespace: instance.Namespace}
+	if err := fakeClient.Get(context.TODO(), cephFsProvisionerKey, cephFsProvisionerSecret); err != nil {
+		t.Fatalf("expected the under-privileged secret to still be created, got error: %v", err)
+	}
+}
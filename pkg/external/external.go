@@ -0,0 +1,175 @@
+// Package external decodes the "external_cluster_details" payload shipped in the
+// rook-ceph-external-cluster-details Secret and exposes it as a typed, versioned structure.
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CurrentAPIVersion is the envelope apiVersion this operator release understands natively.
+const CurrentAPIVersion = "external.ocs.openshift.io/v1"
+
+// legacyAPIVersion is assigned to payloads that predate the versioned envelope, i.e. a bare
+// JSON array of ExternalResource. It is never written by this operator, only read.
+const legacyAPIVersion = "external.ocs.openshift.io/legacy"
+
+// OperatorVersion is this operator build's own version. It is checked against
+// compatibleAPIVersions' matrix so a given envelope apiVersion is only accepted by the range of
+// operator releases known to interoperate with it, rather than just checking the apiVersion
+// string is one this build happens to have a decode path for.
+var OperatorVersion = "4.6.0"
+
+// apiVersionRange is the range of operator releases, inclusive of minOperatorVersion and
+// exclusive of maxOperatorVersion, that are known to safely interoperate with an envelope
+// apiVersion. An empty maxOperatorVersion means no upper bound has been set yet.
+type apiVersionRange struct {
+	minOperatorVersion string
+	maxOperatorVersion string
+}
+
+// compatibleAPIVersions enumerates the envelope versions this operator release can decode,
+// together with the operator-version range each envelope version is compatible with.
+var compatibleAPIVersions = map[string]apiVersionRange{
+	CurrentAPIVersion: {minOperatorVersion: "4.6.0"},
+	legacyAPIVersion:  {minOperatorVersion: "4.3.0"},
+}
+
+// compareVersions compares two dotted numeric version strings (e.g. "4.6.0"), returning -1, 0,
+// or 1 as a is less than, equal to, or greater than b. Missing trailing segments compare as 0.
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+		if aNum != bNum {
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// isOperatorCompatible reports whether OperatorVersion falls within the given apiVersionRange.
+func isOperatorCompatible(r apiVersionRange) bool {
+	if compareVersions(OperatorVersion, r.minOperatorVersion) < 0 {
+		return false
+	}
+	if r.maxOperatorVersion != "" && compareVersions(OperatorVersion, r.maxOperatorVersion) >= 0 {
+		return false
+	}
+	return true
+}
+
+// rgwObjectUserSecretName is the RGW admin-ops object user Secret Noobaa's backingstore uses
+// to talk to the external RGW. A CephFS StorageClass request requires its MDS/CSI user Secret;
+// an RGW StorageClass request requires this one.
+const rgwObjectUserSecretName = "rgw-admin-ops-user"
+
+// ExternalResource describes a single resource (ConfigMap, Secret, StorageClass parameters, or a
+// RadosNamespace/OMapConfig/CephObjectStore description) requested by the external Ceph cluster.
+type ExternalResource struct {
+	Kind string            `json:"kind"`
+	Data map[string]string `json:"data"`
+	Name string            `json:"name"`
+}
+
+// ExternalClusterInfo is the versioned envelope carried by the external cluster details
+// Secret. Fields beyond Resources were added as external releases grew new capabilities
+// (rados namespaces, blocklist caps, multiple RGW endpoints, ...) and are optional.
+type ExternalClusterInfo struct {
+	APIVersion   string             `json:"apiVersion"`
+	Resources    []ExternalResource `json:"resources"`
+	CSIConfig    map[string]string  `json:"csiConfig"`
+	CephVersion  string             `json:"cephVersion"`
+	Capabilities []string           `json:"capabilities"`
+}
+
+// Decode parses the raw external_cluster_details Secret data into an ExternalClusterInfo.
+// It understands both the current versioned envelope and the legacy bare array of
+// ExternalResource used by older external-cluster scripts.
+func Decode(secretData []byte) (*ExternalClusterInfo, error) {
+	info := &ExternalClusterInfo{}
+	if err := json.Unmarshal(secretData, info); err != nil || info.APIVersion == "" {
+		var resources []ExternalResource
+		if legacyErr := json.Unmarshal(secretData, &resources); legacyErr != nil {
+			return nil, fmt.Errorf("could not parse external cluster details as a versioned envelope or a legacy resource list: %v", legacyErr)
+		}
+		info = &ExternalClusterInfo{APIVersion: legacyAPIVersion, Resources: resources}
+	}
+	versionRange, known := compatibleAPIVersions[info.APIVersion]
+	if !known {
+		return nil, fmt.Errorf("external cluster details apiVersion %q is not compatible with this operator, expected %q", info.APIVersion, CurrentAPIVersion)
+	}
+	if !isOperatorCompatible(versionRange) {
+		return nil, fmt.Errorf("external cluster details apiVersion %q is not compatible with operator version %q (requires >= %q, < %q)",
+			info.APIVersion, OperatorVersion, versionRange.minOperatorVersion, versionRange.maxOperatorVersion)
+	}
+	if err := info.validate(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// validate checks that, for each feature signalled by the resource list, the resources
+// required to use it safely are all present.
+func (eci *ExternalClusterInfo) validate() error {
+	if cephFS, ok := eci.GetResource("StorageClass", "cephfs"); ok {
+		if cephFS.Data["fsName"] == "" {
+			return fmt.Errorf("external cluster details requests a CephFS StorageClass but is missing the fsName")
+		}
+		if _, ok := eci.GetResource("Secret", "rook-csi-cephfs-provisioner"); !ok {
+			return fmt.Errorf("external cluster details requests a CephFS StorageClass but is missing the MDS/CSI user secret")
+		}
+	}
+	if rgw, ok := eci.GetResource("StorageClass", "ceph-rgw"); ok {
+		if rgw.Data["endpoint"] == "" {
+			return fmt.Errorf("external cluster details requests an RGW StorageClass but is missing the endpoint")
+		}
+		if _, ok := eci.GetResource("Secret", rgwObjectUserSecretName); !ok {
+			return fmt.Errorf("external cluster details requests an RGW StorageClass but is missing the object user secret")
+		}
+	}
+	return nil
+}
+
+// GetResource returns the first resource matching kind and name.
+func (eci *ExternalClusterInfo) GetResource(kind, name string) (ExternalResource, bool) {
+	for _, res := range eci.Resources {
+		if res.Kind == kind && res.Name == name {
+			return res, true
+		}
+	}
+	return ExternalResource{}, false
+}
+
+// GetResourcesByKind returns every resource of the given kind, in the order they were declared.
+func (eci *ExternalClusterInfo) GetResourcesByKind(kind string) []ExternalResource {
+	var matches []ExternalResource
+	for _, res := range eci.Resources {
+		if res.Kind == kind {
+			matches = append(matches, res)
+		}
+	}
+	return matches
+}
+
+// HasCapability reports whether the external cluster advertised the named capability.
+func (eci *ExternalClusterInfo) HasCapability(name string) bool {
+	for _, cap := range eci.Capabilities {
+		if cap == name {
+			return true
+		}
+	}
+	return false
+}